@@ -0,0 +1,314 @@
+package srv
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One row of a directory listing, backend-agnostic.
+type DirEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+/*
+Optional `VFS` capability for backends that can enumerate a directory's
+immediate children. Required for `FileServer.AutoIndex` and for `?download=`.
+*/
+type ListVFS interface {
+	VFS
+	ReadDir(name string) ([]DirEntry, error)
+}
+
+func (self fsVFS) ReadDir(name string) ([]DirEntry, error) {
+	entries, err := fs.ReadDir(self.FS, name)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, DirEntry{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime(), IsDir: entry.IsDir()})
+	}
+	return out, nil
+}
+
+/*
+If `self.AutoIndex` is on and `name` is a directory, and the request carries
+`?download=zip`/`?download=tar.gz`, streams an archive of the subtree.
+Checked before the usual `.html`/`index.html` resolution, so it works
+whether or not the directory has its own `index.html`.
+*/
+func (self FileServer) serveAutoIndexDownload(rew http.ResponseWriter, req *http.Request, name string) bool {
+	format := req.URL.Query().Get("download")
+	if !self.AutoIndex || format == "" {
+		return false
+	}
+	lister, ok := self.lister(name)
+	if !ok {
+		return false
+	}
+	self.serveDownload(rew, lister, name, format)
+	return true
+}
+
+/*
+If `self.AutoIndex` is on and `name` is a directory without its own
+`index.html`, renders a listing: HTML by default, or JSON for
+`Accept: application/json`. Returns `true` if it handled the request.
+*/
+func (self FileServer) serveAutoIndex(rew http.ResponseWriter, req *http.Request, name string) bool {
+	lister, ok := self.lister(name)
+	if !ok {
+		return false
+	}
+
+	entries, err := lister.ReadDir(name)
+	if err != nil {
+		panic(err)
+	}
+	// `url.URL.Query` drops ";"-joined pairs, so split on both separators.
+	query, _ := url.ParseQuery(strings.ReplaceAll(req.URL.RawQuery, ";", "&"))
+	column, desc := sortEntries(entries, query)
+
+	if acceptsJSON(req.Header.Get("Accept")) {
+		rew.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rew).Encode(entries); err != nil {
+			panic(err)
+		}
+		return true
+	}
+
+	rew.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writeIndexHTML(rew, name, entries, column, desc)
+	return true
+}
+
+func acceptsJSON(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		typ, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if typ == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Sorts `entries` in place per Apache-style `?C=<column>;O=<A|D>` params
+(`C` one of `N`ame, `S`ize, `M`odified; `O` ascending/descending). Defaults
+to name ascending, with directories always listed first. Returns the
+resolved column/direction so `writeIndexHTML` can render column headers
+that toggle to the opposite direction.
+*/
+func sortEntries(entries []DirEntry, query map[string][]string) (column string, desc bool) {
+	column = "N"
+	if vals := query["C"]; len(vals) > 0 && vals[0] != "" {
+		column = vals[0]
+	}
+	if vals := query["O"]; len(vals) > 0 && vals[0] == "D" {
+		desc = true
+	}
+
+	less := func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		switch column {
+		case "S":
+			return a.Size < b.Size
+		case "M":
+			return a.ModTime.Before(b.ModTime)
+		default:
+			return a.Name < b.Name
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if desc && entries[i].IsDir == entries[j].IsDir {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+	return column, desc
+}
+
+func writeIndexHTML(rew http.ResponseWriter, name string, entries []DirEntry, column string, desc bool) {
+	title := "/" + strings.TrimPrefix(name, ".")
+	fmt.Fprintf(rew, `<!doctype html><html><head><meta charset="utf-8"><title>%s</title></head><body>`, html.EscapeString(title))
+	fmt.Fprintf(rew, `<h1>%s</h1><table><tr><th>%s</th><th>%s</th><th>%s</th></tr>`, html.EscapeString(title),
+		sortHeader("N", "Name", column, desc), sortHeader("S", "Size", column, desc), sortHeader("M", "Modified", column, desc))
+	if name != "." {
+		fmt.Fprint(rew, `<tr><td><a href="../">../</a></td><td></td><td></td></tr>`)
+	}
+	for _, entry := range entries {
+		href := url.PathEscape(entry.Name)
+		text := entry.Name
+		size := strconv.FormatInt(entry.Size, 10)
+		if entry.IsDir {
+			href += "/"
+			text += "/"
+			size = ""
+		}
+		fmt.Fprintf(rew, `<tr><td><a href="%s">%s</a></td><td>%s</td><td>%s</td></tr>`,
+			html.EscapeString(href), html.EscapeString(text), size, entry.ModTime.Format(time.RFC3339))
+	}
+	fmt.Fprint(rew, `</table></body></html>`)
+}
+
+/*
+Renders one sortable column header. Clicking the currently-active column
+toggles its direction; clicking any other column switches to it, ascending.
+*/
+func sortHeader(col, label, activeColumn string, activeDesc bool) string {
+	order := "A"
+	if col == activeColumn && !activeDesc {
+		order = "D"
+	}
+	return fmt.Sprintf(`<a href="?C=%s&O=%s">%s</a>`, url.QueryEscape(col), url.QueryEscape(order), html.EscapeString(label))
+}
+
+// Streams a freshly built archive of the subtree rooted at `name`.
+func (self FileServer) serveDownload(rew http.ResponseWriter, lister ListVFS, name string, format string) {
+	base := path.Base(name)
+	if name == "." {
+		base = "site"
+	}
+
+	switch format {
+	case "zip":
+		rew.Header().Set("Content-Type", "application/zip")
+		rew.Header().Set("Content-Disposition", `attachment; filename="`+base+`.zip"`)
+		writer := zip.NewWriter(rew)
+		err := walkVFS(lister, name, func(filePath string) error {
+			rel := relPath(filePath, name)
+			entryWriter, err := writer.Create(rel)
+			if err != nil {
+				return err
+			}
+			return copyFile(entryWriter, self.VFS, filePath)
+		})
+		if err != nil {
+			panic(err)
+		}
+		if err := writer.Close(); err != nil {
+			panic(err)
+		}
+
+	case "tar.gz":
+		rew.Header().Set("Content-Type", "application/gzip")
+		rew.Header().Set("Content-Disposition", `attachment; filename="`+base+`.tar.gz"`)
+		gzWriter := gzip.NewWriter(rew)
+		tarWriter := tar.NewWriter(gzWriter)
+		err := walkVFS(lister, name, func(filePath string) error {
+			rel := relPath(filePath, name)
+			stat, err := self.VFS.Stat(filePath)
+			if err != nil {
+				return err
+			}
+			if err := tarWriter.WriteHeader(&tar.Header{Name: rel, Size: stat.Size(), Mode: 0o644, ModTime: stat.ModTime()}); err != nil {
+				return err
+			}
+			return copyFile(tarWriter, self.VFS, filePath)
+		})
+		if err != nil {
+			panic(err)
+		}
+		if err := tarWriter.Close(); err != nil {
+			panic(err)
+		}
+		if err := gzWriter.Close(); err != nil {
+			panic(err)
+		}
+
+	default:
+		http.Error(rew, "srv: unsupported download format "+strconv.Quote(format), http.StatusBadRequest)
+	}
+}
+
+/*
+Strips the downloaded subtree's root (`name`) off `filePath`, for use as an
+archive entry name. `name == "."` means the whole `VFS` is being downloaded,
+in which case `filePath` already has no such prefix to strip: trimming a
+literal "." off it would also eat a leading "." from any root-level dotfile
+or dot-directory, e.g. turning ".well-known/challenge" into
+"well-known/challenge".
+*/
+func relPath(filePath, name string) string {
+	if name == "." {
+		return filePath
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(filePath, name), "/")
+}
+
+func copyFile(dst io.Writer, vfs VFS, name string) error {
+	file, err := vfs.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(dst, file)
+	return err
+}
+
+// Recursively visits every regular file under `name`, depth-first.
+func walkVFS(lister ListVFS, name string, fn func(filePath string) error) error {
+	entries, err := lister.ReadDir(name)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		childPath := entry.Name
+		if name != "." {
+			childPath = name + "/" + entry.Name
+		}
+		if entry.IsDir {
+			if err := walkVFS(lister, childPath, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(childPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self FileServer) statDir(name string) bool {
+	stat, err := self.VFS.Stat(name)
+	return err == nil && stat.IsDir()
+}
+
+// Returns `self.VFS` as a `ListVFS`, if `AutoIndex` is on and `name` is a directory.
+func (self FileServer) lister(name string) (ListVFS, bool) {
+	if !self.AutoIndex {
+		return nil, false
+	}
+	lister, ok := self.VFS.(ListVFS)
+	if !ok || !self.statDir(name) {
+		return nil, false
+	}
+	return lister, true
+}
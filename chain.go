@@ -0,0 +1,39 @@
+package srv
+
+import (
+	"net/http"
+
+	"github.com/yury-egorenkov/srv/serving"
+)
+
+/*
+Composes several `serving.Serving` backends into one `http.Handler`, trying
+each in order and falling through to the next when it reports
+`handled == false` — the multi-backend counterpart to the disk → ".html" →
+"index.html" resolution `FileServer` already does internally for a single
+`VFS`. Typically a local overlay directory in front of a zip-backed site,
+terminated by a fallback page:
+
+	srv.Chain(disk.New("./overrides"), zipfs.New("./site.zip"), fallback.HTML("./public"))
+
+Unlike buffering and replaying whichever handler's response isn't a 404,
+each `Serving` decides for itself whether it has a match, so nothing is
+written twice and a `Serving` that errors (as opposed to one that simply
+lacks the file) stops the chain immediately instead of being treated as a
+404. If none of `servings` handles the request, `Chain` itself responds
+with a 404.
+*/
+func Chain(servings ...serving.Serving) http.Handler {
+	return http.HandlerFunc(func(rew http.ResponseWriter, req *http.Request) {
+		for _, one := range servings {
+			handled, err := one.Serve(rew, req)
+			if err != nil {
+				panic(err)
+			}
+			if handled {
+				return
+			}
+		}
+		http.NotFound(rew, req)
+	})
+}
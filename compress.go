@@ -0,0 +1,225 @@
+package srv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+Below this size, on-the-fly gzip isn't worth the CPU: the framing overhead
+eats most of the savings and the client round-trip is already one packet.
+*/
+const gzipMinSize = 1024
+
+/*
+Precompressed sidecar extensions, checked in preference order (brotli first,
+since it usually compresses smaller than gzip).
+*/
+var sidecarEncodings = []struct{ encoding, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+/*
+Process-wide cache of on-the-fly gzip output, keyed by backend identity +
+file name + mtime. The backend identity matters because a single process
+may serve more than one `FileServer` (e.g. via `Chain`); without it, two
+different sites with a same-named file at the same mtime would serve each
+other's compressed bytes. Deliberately small: it exists to avoid
+re-compressing the same file on every request in a short span, not to
+cache an entire site.
+*/
+var gzipCache = newLRU(64)
+
+/*
+If a precompressed sidecar (`<name>.gz`/`<name>.br`) exists and the client's
+`Accept-Encoding` allows it, serves it in place of `name` and returns `true`.
+`Content-Type` is derived from `name`, not the sidecar, so `index.html.gz`
+still serves as `text/html`.
+*/
+func (self FileServer) serveSidecar(rew http.ResponseWriter, req *http.Request, name string) bool {
+	accepted := parseAcceptEncoding(req.Header.Get("Accept-Encoding"))
+
+	for _, cand := range sidecarEncodings {
+		if !accepted[cand.encoding] {
+			continue
+		}
+		sidecarName := name + cand.ext
+		if !self.statFile(sidecarName) {
+			continue
+		}
+
+		file, stat, ok := self.open(rew, req, sidecarName)
+		if !ok {
+			return true
+		}
+		defer file.Close()
+
+		seeker, ok := file.(io.ReadSeeker)
+		if !ok {
+			panic(errors.New(`srv: VFS file ` + sidecarName + ` does not support seeking, required for http.ServeContent`))
+		}
+
+		rew.Header().Set("Content-Type", contentType(name))
+		rew.Header().Set("Content-Encoding", cand.encoding)
+		rew.Header().Set("Vary", "Accept-Encoding")
+		setETag(rew, file)
+		http.ServeContent(rew, req, name, stat.ModTime(), seeker)
+		return true
+	}
+
+	return false
+}
+
+/*
+If the client accepts gzip and `name` is worth compressing, serves a
+gzip-compressed rendition of the already-open `file` and returns `true`.
+The compressed bytes are cached (see `gzipCache`) so repeat requests for the
+same file+mtime skip re-compression.
+*/
+func (self FileServer) serveGzipped(rew http.ResponseWriter, req *http.Request, name string, stat fs.FileInfo, file fs.File) bool {
+	if stat.Size() < gzipMinSize || !isCompressible(contentType(name)) {
+		return false
+	}
+	if !parseAcceptEncoding(req.Header.Get("Accept-Encoding"))["gzip"] {
+		return false
+	}
+
+	gzipped, err := gzipContent(self.VFS, name, stat.ModTime().UnixNano(), file)
+	if err != nil {
+		panic(err)
+	}
+
+	rew.Header().Set("Content-Type", contentType(name))
+	rew.Header().Set("Content-Encoding", "gzip")
+	rew.Header().Set("Vary", "Accept-Encoding")
+	http.ServeContent(rew, req, name, stat.ModTime(), bytes.NewReader(gzipped))
+	return true
+}
+
+/*
+Returns the gzip-compressed bytes of `file`, reusing a cached copy keyed by
+backend + name + mtime when available.
+*/
+func gzipContent(vfs VFS, name string, mtimeNano int64, file io.Reader) ([]byte, error) {
+	key := fmt.Sprintf("%p", vfs) + "|" + name + "@" + strconv.FormatInt(mtimeNano, 10)
+
+	if cached, ok := gzipCache.get(key); ok {
+		return cached, nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := io.Copy(writer, file); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	out := buf.Bytes()
+	gzipCache.put(key, out)
+	return out, nil
+}
+
+func contentType(name string) string {
+	return mime.TypeByExtension(path.Ext(name))
+}
+
+func isCompressible(contentType string) bool {
+	typ, _, _ := strings.Cut(contentType, ";")
+	typ = strings.TrimSpace(typ)
+
+	if strings.HasPrefix(typ, "text/") {
+		return true
+	}
+	switch typ {
+	case "application/json", "application/javascript", "application/xml",
+		"application/wasm", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+/*
+Parses `Accept-Encoding` into a set of acceptable encoding names, honoring
+`q=0` exclusions but otherwise ignoring quality ordering: we only ever pick
+between "br" and "gzip" by our own preference, not the client's.
+*/
+func parseAcceptEncoding(header string) map[string]bool {
+	out := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		if _, qval, ok := strings.Cut(params, "q="); ok {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(qval), 64); err == nil && q == 0 {
+				continue
+			}
+		}
+		out[name] = true
+	}
+	return out
+}
+
+/*
+Small LRU cache of byte slices. Not optimized for large sizes: eviction and
+lookups are both O(n) over the key order, which is fine for the handful of
+entries this package ever caches.
+*/
+type lru struct {
+	mu      sync.Mutex
+	max     int
+	order   []string
+	entries map[string][]byte
+}
+
+func newLRU(max int) *lru { return &lru{max: max, entries: map[string][]byte{}} }
+
+func (self *lru) get(key string) ([]byte, bool) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	val, ok := self.entries[key]
+	if ok {
+		self.touch(key)
+	}
+	return val, ok
+}
+
+func (self *lru) put(key string, val []byte) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.entries[key] = val
+	self.touch(key)
+
+	for len(self.order) > self.max {
+		delete(self.entries, self.order[0])
+		self.order = self.order[1:]
+	}
+}
+
+// Caller must hold `self.mu`.
+func (self *lru) touch(key string) {
+	for ind, val := range self.order {
+		if val == key {
+			self.order = append(self.order[:ind], self.order[ind+1:]...)
+			break
+		}
+	}
+	self.order = append(self.order, key)
+}
@@ -0,0 +1,138 @@
+package srv
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+
+	"github.com/yury-egorenkov/srv/rules"
+)
+
+/*
+Loads `_redirects` and `_headers` from the root of `vfs`, if present. Either
+file being absent is not an error; any other error (including a malformed
+file) is returned as-is.
+*/
+func LoadRules(vfs VFS) (rules.Rules, error) {
+	redirects, err := openRulesFile(vfs, "_redirects")
+	if err != nil {
+		return rules.Rules{}, err
+	}
+	if redirects != nil {
+		defer redirects.Close()
+	}
+
+	headers, err := openRulesFile(vfs, "_headers")
+	if err != nil {
+		return rules.Rules{}, err
+	}
+	if headers != nil {
+		defer headers.Close()
+	}
+
+	return rules.Parse(redirects, headers)
+}
+
+func openRulesFile(vfs VFS, name string) (fs.File, error) {
+	file, err := vfs.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return file, err
+}
+
+/*
+Lazily loads and caches the result of `LoadRules` for a `FileServer`
+constructed via `New`; see `FileServer.rules`. Deliberately doesn't use
+`sync.Once`, mirroring `remoteZipVFS.init`: some backends can only find out
+whether `_redirects`/`_headers` exist by making a network request, and a
+transient failure there must not permanently leave the `FileServer` running
+without whatever rules it was supposed to have. Only a successful load is
+cached; a failed attempt leaves `loaded` false so the next request tries
+again.
+*/
+type rulesCache struct {
+	mu     sync.Mutex
+	loaded bool
+	rules  rules.Rules
+}
+
+func (self *rulesCache) get(vfs VFS) rules.Rules {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.loaded {
+		return self.rules
+	}
+
+	loaded, err := LoadRules(vfs)
+	if err != nil {
+		return rules.Rules{}
+	}
+
+	self.rules = loaded
+	self.loaded = true
+	return self.rules
+}
+
+/*
+Writes a `_headers` match to `rew`, returning whatever was there before so
+`restoreHeaders` can put it back. Split out from `FileServer.resolve` so
+callers can commit the match early (`_headers` rules apply regardless of
+what's ultimately served) yet still undo it if resolution turns up nothing,
+e.g. `FileServer.Serve` in a `Chain`.
+*/
+func applyHeaders(rew http.ResponseWriter, headers map[string]string) map[string][]string {
+	prior := make(map[string][]string, len(headers))
+	for key, val := range headers {
+		// Canonicalize before snapshotting: two `_headers` matches whose
+		// names differ only in case (e.g. "cache-control" and
+		// "Cache-Control") address the same entry in `rew.Header()`, so the
+		// snapshot must be taken once, before either has been applied.
+		canon := http.CanonicalHeaderKey(key)
+		if _, seen := prior[canon]; !seen {
+			prior[canon] = append([]string(nil), rew.Header().Values(canon)...)
+		}
+		rew.Header().Set(key, val)
+	}
+	return prior
+}
+
+// Reverts `applyHeaders`, restoring each header to whatever it held before
+// (or removing it, if it wasn't set at all).
+func restoreHeaders(rew http.ResponseWriter, prior map[string][]string) {
+	for key, vals := range prior {
+		if len(vals) == 0 {
+			rew.Header().Del(key)
+			continue
+		}
+		rew.Header()[http.CanonicalHeaderKey(key)] = vals
+	}
+}
+
+// Reports whether `to` is an absolute URL, i.e. a rewrite target for
+// `proxy` to reverse-proxy to, as opposed to a local path to re-resolve
+// within this `VFS`.
+func isAbsoluteURL(to string) bool {
+	target, err := url.Parse(to)
+	return err == nil && target.IsAbs()
+}
+
+// Reverse-proxies the request to an absolute URL named by a rewrite rule.
+func (self FileServer) proxy(rew http.ResponseWriter, req *http.Request, rawURL string) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		panic(err)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.URL.Path = target.Path
+	}
+	proxy.ServeHTTP(rew, req)
+}
@@ -0,0 +1,84 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A rewrite ("_redirects" rule with status 200) to a local path must be
+// re-resolved against this VFS, not handed to the reverse proxy: the SPA
+// fallback idiom "/*  /index.html  200" has no scheme/host for
+// httputil.NewSingleHostReverseProxy to dial.
+func TestFileServerLocalRewrite(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_redirects", "/*  /index.html  200\n")
+	writeFile(t, dir, "index.html", "<html>app shell</html>")
+
+	server := New(LocalDir(dir))
+
+	rew := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/some/client/route", nil)
+	server.ServeHTTP(rew, req)
+
+	if rew.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rew.Code)
+	}
+	if body := rew.Body.String(); body != "<html>app shell</html>" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
+
+// A rewrite to an absolute URL is still reverse-proxied.
+func TestFileServerAbsoluteRewriteProxies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rew http.ResponseWriter, req *http.Request) {
+		rew.Write([]byte("from backend: " + req.URL.Path))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	writeFile(t, dir, "_redirects", "/api/*  "+backend.URL+"/:splat  200\n")
+
+	server := New(LocalDir(dir))
+
+	rew := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/widgets", nil)
+	server.ServeHTTP(rew, req)
+
+	if rew.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rew.Code)
+	}
+	if body := rew.Body.String(); body != "from backend: /widgets" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
+
+// A non-200 redirect rule still issues a browser redirect, unaffected by the
+// rewrite-vs-proxy branching above.
+func TestFileServerRedirect(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "_redirects", "/old  /new  301\n")
+	writeFile(t, dir, "new.html", "new page")
+
+	server := New(LocalDir(dir))
+
+	rew := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/old", nil)
+	server.ServeHTTP(rew, req)
+
+	if rew.Code != http.StatusMovedPermanently {
+		t.Fatalf("expected 301, got %d", rew.Code)
+	}
+	if loc := rew.Header().Get("Location"); loc != "/new" {
+		t.Fatalf("unexpected Location: %q", loc)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Parses Netlify-style `_redirects` and `_headers` files. See
+https://docs.netlify.com/routing/redirects/ and
+https://docs.netlify.com/routing/headers/ for the (subset of the) syntax
+supported here.
+*/
+package rules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+One line of a `_redirects` file: `From  To  [Status]`. `From` may end with a
+`*` wildcard, whose match is available in `To` as `:splat`. `Status` defaults
+to 301. A rule whose `Status` is 200 is a rewrite/proxy rather than a
+redirect: the response is served from `To` without changing the visited URL.
+*/
+type Redirect struct {
+	From   string
+	To     string
+	Status int
+}
+
+/*
+Reports whether `reqPath` matches this rule, returning the destination with
+`:splat` substituted in.
+*/
+func (self Redirect) Match(reqPath string) (string, bool) {
+	prefix, wildcard := splitWildcard(self.From)
+	if wildcard {
+		if !strings.HasPrefix(reqPath, prefix) {
+			return "", false
+		}
+		splat := reqPath[len(prefix):]
+		return strings.ReplaceAll(self.To, ":splat", splat), true
+	}
+	if reqPath != self.From {
+		return "", false
+	}
+	return self.To, true
+}
+
+// Reports whether this rule rewrites/proxies in place rather than redirecting.
+func (self Redirect) IsRewrite() bool { return self.Status == http.StatusOK }
+
+/*
+One block of a `_headers` file: a path prefix (optionally ending with `*`)
+followed by indented `Key: Value` lines.
+*/
+type HeaderRule struct {
+	Prefix  string
+	Headers map[string]string
+}
+
+// Reports whether `reqPath` falls under this rule's prefix.
+func (self HeaderRule) Match(reqPath string) bool {
+	prefix, wildcard := splitWildcard(self.Prefix)
+	if wildcard {
+		return strings.HasPrefix(reqPath, prefix)
+	}
+	return reqPath == self.Prefix
+}
+
+// Parsed `_redirects` and `_headers` files for one served root.
+type Rules struct {
+	Redirects []Redirect
+	Headers   []HeaderRule
+}
+
+/*
+Returns headers from every rule matching `reqPath`, in file order, later
+rules overriding earlier ones on key conflicts.
+*/
+func (self Rules) MatchHeaders(reqPath string) map[string]string {
+	out := map[string]string{}
+	for _, rule := range self.Headers {
+		if rule.Match(reqPath) {
+			for key, val := range rule.Headers {
+				out[key] = val
+			}
+		}
+	}
+	return out
+}
+
+// Returns the first redirect rule matching `reqPath`, if any.
+func (self Rules) MatchRedirect(reqPath string) (Redirect, bool) {
+	for _, rule := range self.Redirects {
+		if dest, ok := rule.Match(reqPath); ok {
+			rule.To = dest
+			return rule, true
+		}
+	}
+	return Redirect{}, false
+}
+
+/*
+Parses a `_redirects` file. Blank lines and lines starting with `#` are
+ignored. Example:
+
+	/old/*  /new/:splat  301
+	/api/*  https://api.example.com/:splat  200
+*/
+func ParseRedirects(src io.Reader) ([]Redirect, error) {
+	var out []Redirect
+
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("rules: malformed redirect line %q", line)
+		}
+
+		status := http.StatusMovedPermanently
+		if len(fields) >= 3 {
+			parsed, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("rules: invalid status in line %q: %w", line, err)
+			}
+			status = parsed
+		}
+
+		out = append(out, Redirect{From: fields[0], To: fields[1], Status: status})
+	}
+
+	return out, scanner.Err()
+}
+
+/*
+Parses a `_headers` file. Blank lines are ignored. Example:
+
+	/*
+	  X-Frame-Options: DENY
+
+	/assets/*
+	  Cache-Control: public, max-age=31536000, immutable
+*/
+func ParseHeaders(src io.Reader) ([]HeaderRule, error) {
+	var out []HeaderRule
+	var cur *HeaderRule
+
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		raw := scanner.Text()
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(raw, " ") && !strings.HasPrefix(raw, "\t") {
+			out = append(out, HeaderRule{Prefix: strings.TrimSpace(raw), Headers: map[string]string{}})
+			cur = &out[len(out)-1]
+			continue
+		}
+
+		if cur == nil {
+			return nil, fmt.Errorf("rules: header line %q has no preceding path", raw)
+		}
+
+		key, val, ok := strings.Cut(strings.TrimSpace(raw), ":")
+		if !ok {
+			return nil, fmt.Errorf("rules: malformed header line %q", raw)
+		}
+		cur.Headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+
+	return out, scanner.Err()
+}
+
+/*
+Parses both files at once. Either reader may be `nil`, in which case that
+half of `Rules` is left empty; this lets callers skip loading a file that
+doesn't exist.
+*/
+func Parse(redirects, headers io.Reader) (Rules, error) {
+	var out Rules
+	var err error
+
+	if redirects != nil {
+		out.Redirects, err = ParseRedirects(redirects)
+		if err != nil {
+			return Rules{}, err
+		}
+	}
+
+	if headers != nil {
+		out.Headers, err = ParseHeaders(headers)
+		if err != nil {
+			return Rules{}, err
+		}
+	}
+
+	return out, nil
+}
+
+func splitWildcard(val string) (prefix string, wildcard bool) {
+	if strings.HasSuffix(val, "*") {
+		return val[:len(val)-1], true
+	}
+	return val, false
+}
@@ -0,0 +1,44 @@
+package srv
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+// A VFS whose Open always fails, simulating a backend (e.g. RemoteZip)
+// whose first network fetch can't reach the origin.
+type unreachableVFS struct{}
+
+func (unreachableVFS) Open(name string) (fs.File, error) {
+	return nil, errors.New("dial tcp: connection refused")
+}
+
+func (unreachableVFS) Stat(name string) (fs.FileInfo, error) {
+	return nil, errors.New("dial tcp: connection refused")
+}
+
+// New must not eagerly fetch _redirects/_headers: a backend whose first
+// access can fail transiently (network hiccup on process startup) must not
+// permanently prevent the server from being constructed at all.
+func TestNewDoesNotEagerlyLoadRules(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("New panicked: %v", r)
+		}
+	}()
+	New(unreachableVFS{})
+}
+
+// A failed lazy load isn't cached: the server keeps trying on subsequent
+// requests instead of running rule-less forever after one transient error.
+func TestRulesCacheRetriesAfterFailure(t *testing.T) {
+	cache := new(rulesCache)
+
+	if got := cache.get(unreachableVFS{}); got.Redirects != nil || got.Headers != nil {
+		t.Fatalf("expected empty rules on failed load, got %+v", got)
+	}
+	if cache.loaded {
+		t.Fatal("a failed load must not be cached")
+	}
+}
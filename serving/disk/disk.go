@@ -0,0 +1,20 @@
+/*
+Serving backend for a local directory tree. Thin wrapper around
+`srv.FileServer` backed by `srv.LocalDir`, meant to be composed into a
+`srv.Chain` alongside `srv/serving/zipfs` and `srv/serving/fallback`, e.g.
+to let a local overlay directory take precedence over a zip-backed site:
+
+	srv.Chain(disk.New("./overrides"), zipfs.New("./site.zip"), fallback.HTML("./public"))
+
+Also usable standalone as an `http.Handler`, same as `srv.New(srv.LocalDir(root))`.
+*/
+package disk
+
+import "github.com/yury-egorenkov/srv"
+
+type Server struct{ srv.FileServer }
+
+// Constructs a Server backed by the local directory at root.
+func New(root string) Server {
+	return Server{srv.New(srv.LocalDir(root))}
+}
@@ -0,0 +1,31 @@
+/*
+Terminal Serving for a srv.Chain: always reports handled, so it belongs
+last, after srv/serving/disk and/or srv/serving/zipfs have had first
+refusal:
+
+	srv.Chain(disk.New("./overrides"), zipfs.New("./site.zip"), fallback.HTML("./public"))
+
+HTML runs the same resolution as srv.FileServer, but serves "404.html" from
+root instead of leaving the request unhandled when nothing else matches.
+*/
+package fallback
+
+import (
+	"net/http"
+
+	"github.com/yury-egorenkov/srv"
+)
+
+type Server struct{ srv.FileServer }
+
+// Constructs a Server that falls back to "404.html" under root.
+func HTML(root string) Server {
+	return Server{srv.New(srv.LocalDir(root))}
+}
+
+// Overrides the embedded FileServer.Serve: a fallback is always the last
+// word, so it never reports handled == false.
+func (self Server) Serve(rew http.ResponseWriter, req *http.Request) (bool, error) {
+	self.FileServer.ServeHTTP(rew, req)
+	return true, nil
+}
@@ -0,0 +1,25 @@
+/*
+Package serving defines the common interface shared by the resolution
+backends under `srv/serving/disk`, `srv/serving/zipfs`, and
+`srv/serving/fallback`, so `srv.Chain` can compose them without knowing
+which one it's talking to.
+*/
+package serving
+
+import "net/http"
+
+/*
+One candidate source of files in a `srv.Chain`: local disk, an archive, or a
+fixed fallback page. `Serve` attempts to resolve and write the response for
+`req`. If it has no matching file, it must return `handled == false` without
+writing anything, so the next `Serving` in the chain gets a turn.
+
+A non-nil `err` is distinct from "not found": it means the `Serving` itself
+failed (e.g. a remote archive's HEAD request errored), not merely that it
+lacks the requested file. `srv.Chain` treats this as terminal rather than
+falling through to the next `Serving`, so a broken backend doesn't silently
+masquerade as a 404.
+*/
+type Serving interface {
+	Serve(rew http.ResponseWriter, req *http.Request) (handled bool, err error)
+}
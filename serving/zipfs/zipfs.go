@@ -0,0 +1,20 @@
+/*
+Serving backend for a local `.zip` archive. Thin wrapper around
+`srv.FileServer` backed by `srv.Zip`, meant to be composed into a
+`srv.Chain` alongside `srv/serving/disk` and `srv/serving/fallback`. Named
+`zipfs` rather than `zip` to avoid shadowing `archive/zip` at call sites:
+
+	srv.Chain(disk.New("./overrides"), zipfs.New("./site.zip"), fallback.HTML("./public"))
+
+Also usable standalone as an `http.Handler`, same as `srv.New(srv.Zip(archivePath))`.
+*/
+package zipfs
+
+import "github.com/yury-egorenkov/srv"
+
+type Server struct{ srv.FileServer }
+
+// Constructs a Server backed by the local .zip archive at archivePath.
+func New(archivePath string) Server {
+	return Server{srv.New(srv.Zip(archivePath))}
+}
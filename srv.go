@@ -1,39 +1,96 @@
 /*
-Extremely simple Go tool that serves files out of a given folder, using a file
-resolution algorithm similar to GitHub Pages, Netlify, or the default Nginx
-config. Useful for local development. Provides a Go "library" (less than 100
-LoC) and an optional CLI tool.
+Simple Go tool that serves files out of a given folder, archive, or remote
+object store, using a file resolution algorithm similar to GitHub Pages,
+Netlify, or the default Nginx config. Useful for local development, and for
+fronting static sites from whatever `VFS` backend fits. Provides a Go
+library and an optional CLI tool.
 
 See `readme.md` for examples and additional details.
 */
 package srv
 
 import (
-	"archive/zip"
 	"errors"
 	"io"
 	"io/fs"
-	"mime"
 	"net/http"
-	"os"
 	"path"
-	"path/filepath"
-	"strings"
-)
 
-const (
-	ZIP_EXT = `.zip`
+	"github.com/yury-egorenkov/srv/rules"
 )
 
+/*
+Abstracts over the various places `FileServer` may read files from: a local
+directory, an archive, or a remote object store. Mirrors the shape of
+`io/fs.FS`, but adds `Stat` because most backends can satisfy it more cheaply
+than the generic `fs.Stat` fallback (which has to `Open` the file anyway).
+*/
+type VFS interface {
+	Open(name string) (fs.File, error)
+	Stat(name string) (fs.FileInfo, error)
+}
+
 /*
 Serves static files, resolving URL/HTML in a fashion similar to the default
 Nginx config, Github Pages, and Netlify. Implements `http.Handler`. Can be used
 as an almost drop-in replacement for `http.FileServer`.
 */
-type FileServer string
+type FileServer struct {
+	VFS VFS
+
+	/*
+		Explicit `_redirects`/`_headers`, for callers that construct
+		`FileServer` directly instead of going through `New`. Left zero by
+		`New` itself, which loads rules lazily instead; see `rulesCache` and
+		`FileServer.rules`.
+	*/
+	Rules rules.Rules
+
+	// Lazily loads and caches whatever `New` would otherwise have loaded
+	// eagerly. Nil for a `FileServer` built by hand, in which case `Rules`
+	// above is used as-is.
+	rulesCache *rulesCache
+
+	/*
+		Opt-in directory listing for directories without an `index.html`, and
+		`?download=zip`/`?download=tar.gz` of a directory's subtree. Requires a
+		`VFS` that implements `ListVFS`; see `autoindex.go`.
+	*/
+	AutoIndex bool
+}
+
+/*
+Constructs a `FileServer` backed by the given `VFS`. `_redirects` and
+`_headers` are loaded from its root lazily, on first request rather than
+here: some backends (e.g. `RemoteZip`) can only find out whether those files
+exist by making a network request, and a transient failure there must not
+make `New` itself fail, the same way `remoteZipVFS.init` avoids letting a
+transient failure permanently poison the backend. Example:
+
+	srv.New(srv.LocalDir("./public"))
+	srv.New(srv.RemoteZip("https://example.com/site.zip"))
+*/
+func New(vfs VFS) FileServer {
+	return FileServer{VFS: vfs, rulesCache: new(rulesCache)}
+}
+
+// Returns the rules to apply for this request: `self.Rules` as assigned, or
+// the lazily-loaded and cached result of `LoadRules` for a `FileServer`
+// constructed via `New`. A failed load isn't cached, so the next request
+// tries again instead of the server running rule-less forever after one
+// transient error.
+func (self FileServer) rules() rules.Rules {
+	if self.rulesCache == nil {
+		return self.Rules
+	}
+	return self.rulesCache.get(self.VFS)
+}
 
 /*
-Implements `http.Hander`.
+Implements `http.Hander`. Consults `self.rules()` first, so `_redirects` and
+`_headers` can override the default resolution below without recompiling.
+Falls back to "404.html" when nothing else matches; see `resolve` for the
+part of this that's reusable without that fallback.
 
 Minor note: this has a race condition between checking for a file's existence
 and actually serving it. Serving a file is not an atomic operation; the file
@@ -41,18 +98,105 @@ may be deleted or changed midway. In a production-grade version, this condition
 would probably be addressed.
 */
 func (self FileServer) ServeHTTP(rew http.ResponseWriter, req *http.Request) {
+	if self.serveMethodGate(rew, req) {
+		return
+	}
+
+	headers := self.rules().MatchHeaders(req.URL.Path)
+	applyHeaders(rew, headers)
+
+	if self.resolve(rew, req) {
+		return
+	}
+	// Minor issue: sends code 200 instead of 404 if "404.html" is found; not
+	// worth fixing for local development.
+	self.serve(rew, req, "404.html")
+}
+
+/*
+Implements `serving.Serving`: resolves and serves `req` against `self.VFS`,
+the same way `ServeHTTP` does, except it reports `handled == false` instead
+of falling back to "404.html" when nothing matches. This is what lets
+`srv/serving/disk` and `srv/serving/zipfs` be composed into a `Chain`
+alongside each other and a `srv/serving/fallback`.
+
+Unlike `ServeHTTP`, a matching `_headers` entry is only committed to `rew`
+once resolution actually finds something to serve. Otherwise it's rolled
+back before returning `handled == false`, so it doesn't leak onto whichever
+later `Serving` in the `Chain` ends up handling the request instead.
+
+Never returns a non-nil error: a `VFS` failure while resolving (e.g. an
+unreachable `RemoteZip`) is treated like a missing file, and `Serve`
+reports `handled == false`.
+*/
+func (self FileServer) Serve(rew http.ResponseWriter, req *http.Request) (bool, error) {
+	if self.serveMethodGate(rew, req) {
+		return true, nil
+	}
+
+	headers := self.rules().MatchHeaders(req.URL.Path)
+	prior := applyHeaders(rew, headers)
+
+	if self.resolve(rew, req) {
+		return true, nil
+	}
+	restoreHeaders(rew, prior)
+	return false, nil
+}
+
+// Handles methods other than GET and HEAD, which every path below assumes
+// (`http.ServeContent` omits the body for HEAD). Returns true if the method
+// was fully handled (i.e. always, except for GET and HEAD).
+func (self FileServer) serveMethodGate(rew http.ResponseWriter, req *http.Request) bool {
 	switch req.Method {
 	default:
 		http.Error(rew, "", http.StatusMethodNotAllowed)
-		return
+		return true
 	case http.MethodHead, http.MethodOptions:
-		return
+		return req.Method != http.MethodHead
 	case http.MethodGet:
+		return false
 	}
+}
 
-	dir := string(self)
-	reqPath := req.URL.Path
-	filePath := fpj(dir, reqPath)
+/*
+Runs the redirect/proxy and file resolution algorithm, assuming any matching
+`_headers` have already been applied by the caller. Returns false, having
+written nothing, if nothing matched.
+*/
+func (self FileServer) resolve(rew http.ResponseWriter, req *http.Request) bool {
+	if redirect, ok := self.rules().MatchRedirect(req.URL.Path); ok {
+		if !redirect.IsRewrite() {
+			http.Redirect(rew, req, redirect.To, redirect.Status)
+			return true
+		}
+		if isAbsoluteURL(redirect.To) {
+			self.proxy(rew, req, redirect.To)
+			return true
+		}
+		// A rewrite to a local path (e.g. the SPA fallback
+		// "/*  /index.html  200") isn't proxied: resolve it as a file path
+		// instead, without changing the visited URL. Deliberately doesn't
+		// re-run `MatchRedirect` on `redirect.To`: a wildcard rule like
+		// "/*  /index.html  200" would otherwise match its own rewrite
+		// target and recurse forever.
+		return self.resolvePath(rew, req, redirect.To)
+	}
+
+	return self.resolvePath(rew, req, req.URL.Path)
+}
+
+// The file-resolution half of `resolve`, over `reqPath` rather than
+// `req.URL.Path` so a local rewrite target can be resolved without
+// re-matching `_redirects`. `req` itself is passed through unchanged to
+// `serve`/`serveAutoIndexDownload`/`serveAutoIndex`, which only look at its
+// method, headers, and query string, never its path.
+func (self FileServer) resolvePath(rew http.ResponseWriter, req *http.Request, reqPath string) bool {
+	name := fsName(reqPath)
+
+	if self.serveAutoIndexDownload(rew, req, name) {
+		return true
+	}
 
 	/**
 	Ends with slash? Return error 404 for hygiene. Directory links must not end
@@ -60,100 +204,119 @@ func (self FileServer) ServeHTTP(rew http.ResponseWriter, req *http.Request) {
 	non-slash URL, which is a good feature but adds latency.
 	*/
 	// if len(reqPath) > 1 && reqPath[len(reqPath)-1] == '/' {
-	// 	goto notFound
+	// 	return false
 	// }
 
-	if fileExists(filePath) {
-		http.ServeFile(rew, req, filePath)
-		return
-	}
-
-	zipFile, inZipFile := splitFilePathWithExt(filePath, ZIP_EXT)
-	if fileExists(zipFile) {
-		err := self.ServeZipFile(rew, req, zipFile, inZipFile)
-		if err != nil {
-			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
-				goto notFound
-			}
-			panic(err)
-		}
-		return
+	if self.statFile(name) {
+		self.serve(rew, req, name)
+		return true
 	}
 
 	// Has extension? Don't bother looking for +".html" or +"/index.html".
 	if path.Ext(reqPath) != "" {
-		goto notFound
+		return false
 	}
 
 	// Try +".html".
 	{
-		candidatePath := filePath + ".html"
-		if fileExists(candidatePath) {
-			http.ServeFile(rew, req, candidatePath)
-			return
+		candidate := name + ".html"
+		if self.statFile(candidate) {
+			self.serve(rew, req, candidate)
+			return true
 		}
 	}
 
 	// Try +"/index.html".
 	{
-		candidatePath := fpj(filePath, "index.html")
-		if fileExists(candidatePath) {
-			http.ServeFile(rew, req, candidatePath)
-			return
+		candidate := path.Join(name, "index.html")
+		if self.statFile(candidate) {
+			self.serve(rew, req, candidate)
+			return true
 		}
 	}
 
-notFound:
-	// Minor issue: sends code 200 instead of 404 if "404.html" is found; not
-	// worth fixing for local development.
-	http.ServeFile(rew, req, fpj(dir, "404.html"))
+	return self.serveAutoIndex(rew, req, name)
 }
 
-func (FileServer) ServeZipFile(rew http.ResponseWriter, req *http.Request, zipFile string, inZipFile string) error {
-	zipReader, err := zip.OpenReader(zipFile)
-	if err != nil {
-		return err
+/*
+Serves one file by name, relative to the VFS root. Prefers a precompressed
+sidecar or an on-the-fly gzipped rendition when the client accepts it; see
+`compress.go`. Panics on unexpected errors, same as the previous
+implementation did for `ServeZipFile`.
+*/
+func (self FileServer) serve(rew http.ResponseWriter, req *http.Request, name string) {
+	if self.serveSidecar(rew, req, name) {
+		return
 	}
-	defer zipReader.Close()
 
-	req.URL.Path = inZipFile
+	file, stat, ok := self.open(rew, req, name)
+	if !ok {
+		return
+	}
+	defer file.Close()
 
-	file, err := zipReader.Open(inZipFile)
-	if err != nil {
-		return err
+	if self.serveGzipped(rew, req, name, stat, file) {
+		return
 	}
-	rew.Header().Set(`Content-Type`, mime.TypeByExtension(filepath.Ext(inZipFile)))
-	io.Copy(rew, file)
-	return nil
-}
 
-func fpj(path ...string) string { return filepath.Join(path...) }
+	seeker, ok := file.(io.ReadSeeker)
+	if !ok {
+		panic(errors.New(`srv: VFS file ` + name + ` does not support seeking, required for http.ServeContent`))
+	}
 
-func fileExists(filePath string) bool {
-	stat, _ := os.Stat(filePath)
-	return stat != nil && !stat.IsDir()
+	setETag(rew, file)
+	http.ServeContent(rew, req, name, stat.ModTime(), seeker)
 }
 
 /*
-Splits a given file path into two parts: the archive part and the file part. The arch
-variable holds the part of the path up to and including the first occurrence of the
-provided extension. The file variable holds the remainder of the path after the
-provided extension.
-
-	splitFilePathWithExt(`/report/archive.zip/public/index.html`, `zip`)
+If `file` can compute its own `ETag` (e.g. a zip entry, from archive mtime
+plus CRC32), sets it so `http.ServeContent` can honor `If-None-Match`.
+*/
+func setETag(rew http.ResponseWriter, file fs.File) {
+	if tagger, ok := file.(interface{ ETag() string }); ok {
+		rew.Header().Set("ETag", tagger.ETag())
+	}
+}
 
-	Returns:
-		arch := `/report/archive.zip`
-		file := `/public/index.html`
+/*
+Opens the named file and stats it, writing a 404 and returning `ok == false`
+if it doesn't exist. Panics on unexpected errors.
 */
-func splitFilePathWithExt(val string, ext string) (arch string, file string) {
-	vals := strings.Split(val, string(filepath.Separator))
-	for ind, val := range vals {
-		if filepath.Ext(val) == ext {
-			arch = filepath.Join(vals[:ind+1]...)
-			file = filepath.Join(vals[ind+1:]...)
-			break
+func (self FileServer) open(rew http.ResponseWriter, req *http.Request, name string) (fs.File, fs.FileInfo, bool) {
+	file, err := self.VFS.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			http.NotFound(rew, req)
+			return nil, nil, false
 		}
+		panic(err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		panic(err)
+	}
+
+	return file, stat, true
+}
+
+func (self FileServer) statFile(name string) bool {
+	stat, err := self.VFS.Stat(name)
+	return err == nil && !stat.IsDir()
+}
+
+/*
+Converts a URL path such as "/foo/bar.html" into a name suitable for `VFS`
+and `io/fs`, which disallow the leading slash and use "." for the root.
+*/
+func fsName(urlPath string) string {
+	name := path.Clean(urlPath)
+	if len(name) > 0 && name[0] == '/' {
+		name = name[1:]
+	}
+	if name == "" {
+		name = "."
 	}
-	return
+	return name
 }
@@ -0,0 +1,377 @@
+package srv
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Backs a `FileServer` by a local directory, e.g. `srv.New(srv.LocalDir("./public"))`.
+Equivalent to the pre-`VFS` behavior of this package.
+*/
+func LocalDir(root string) VFS { return &fsVFS{os.DirFS(root)} }
+
+/*
+Backs a `FileServer` by the contents of a local `.zip` archive, e.g.
+`srv.New(srv.Zip("./site.zip"))`. Replaces the previous ad-hoc handling of
+`.zip` files found by splitting the request path.
+*/
+func Zip(archivePath string) VFS { return &zipVFS{path: archivePath} }
+
+/*
+Backs a `FileServer` by the contents of a local `.tar` or `.tar.gz` archive,
+e.g. `srv.New(srv.TarGz("./site.tar.gz"))`. The whole archive is read into
+memory on first use; `.tar` archives have no central directory, so unlike
+`.zip` there's no way to support this without either an index pass or fully
+buffering contents.
+*/
+func TarGz(archivePath string) VFS { return &tarVFS{path: archivePath} }
+
+/*
+Backs a `FileServer` by a `.zip` archive fetched over HTTP, using ranged GET
+requests so `archive/zip` can read the central directory and individual
+entries without downloading the whole file. Requires the server to support
+`Range` requests (most object storage, e.g. S3 or GCS, does).
+*/
+func RemoteZip(url string) VFS { return &remoteZipVFS{url: url} }
+
+/*
+Adapts any `io/fs.FS` into a `VFS`, deriving `Stat` via `fs.Stat` when the
+underlying `FS` doesn't implement `fs.StatFS` itself.
+*/
+type fsVFS struct{ fs.FS }
+
+func (self fsVFS) Open(name string) (fs.File, error)     { return self.FS.Open(name) }
+func (self fsVFS) Stat(name string) (fs.FileInfo, error) { return fs.Stat(self.FS, name) }
+
+/*
+Local `.zip` archive. See `zipfile.go` for how entries are turned into
+seekable files: the archive itself is kept open in a small process-wide LRU,
+keyed by path+mtime, so repeat requests don't re-open the file and re-parse
+the central directory.
+*/
+type zipVFS struct{ path string }
+
+func (self *zipVFS) Open(name string) (fs.File, error) {
+	opened, err := zipCache.get(self.path)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := findZipEntry(opened.reader, name)
+	if err != nil {
+		return nil, err
+	}
+	// Acquired for the duration of the returned file's reads; released on
+	// Close (see `newZipEntryFile`) so eviction can't close the archive out
+	// from under an in-flight Store-method read.
+	opened.acquire()
+	return newZipEntryFile(opened.file, opened.mtime, entry, opened.release)
+}
+
+func (self *zipVFS) ReadDir(name string) ([]DirEntry, error) {
+	opened, err := zipCache.get(self.path)
+	if err != nil {
+		return nil, err
+	}
+	return zipReadDir(opened.reader.File, name), nil
+}
+
+func (self *zipVFS) Stat(name string) (fs.FileInfo, error) {
+	opened, err := zipCache.get(self.path)
+	if err != nil {
+		return nil, err
+	}
+	return zipStat(opened.reader.File, opened.mtime, name)
+}
+
+/*
+Local `.tar` or `.tar.gz` archive, fully buffered in memory on first access
+because `archive/tar` has no random access and no central directory to seek
+through.
+*/
+type tarVFS struct {
+	path string
+
+	once    sync.Once
+	entries map[string]*tarEntry
+	err     error
+}
+
+type tarEntry struct {
+	header *tar.Header
+	data   []byte
+}
+
+func (self *tarVFS) init() (map[string]*tarEntry, error) {
+	self.once.Do(func() {
+		file, err := os.Open(self.path)
+		if err != nil {
+			self.err = err
+			return
+		}
+		defer file.Close()
+
+		var reader io.Reader = file
+		if isGzipPath(self.path) {
+			gzReader, err := gzip.NewReader(file)
+			if err != nil {
+				self.err = err
+				return
+			}
+			defer gzReader.Close()
+			reader = gzReader
+		}
+
+		entries := map[string]*tarEntry{}
+		tarReader := tar.NewReader(reader)
+		for {
+			header, err := tarReader.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				self.err = err
+				return
+			}
+			if header.Typeflag != tar.TypeReg {
+				continue
+			}
+			data, err := io.ReadAll(tarReader)
+			if err != nil {
+				self.err = err
+				return
+			}
+			entries[header.Name] = &tarEntry{header, data}
+		}
+		self.entries = entries
+	})
+	return self.entries, self.err
+}
+
+func (self *tarVFS) Open(name string) (fs.File, error) {
+	entries, err := self.init()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &tarFile{entry: entry, reader: bytes.NewReader(entry.data)}, nil
+}
+
+func (self *tarVFS) Stat(name string) (fs.FileInfo, error) {
+	entries, err := self.init()
+	if err != nil {
+		return nil, err
+	}
+	if entry, ok := entries[name]; ok {
+		return entry.header.FileInfo(), nil
+	}
+	if name == "." || tarHasChild(entries, name) {
+		return syntheticDirInfo{name: fsBase(name)}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (self *tarVFS) ReadDir(name string) ([]DirEntry, error) {
+	entries, err := self.init()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+
+	seen := map[string]DirEntry{}
+	for path, entry := range entries {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		rest := path[len(prefix):]
+		if rest == "" {
+			continue
+		}
+		if idx := strings.IndexByte(rest, '/'); idx >= 0 {
+			child := rest[:idx]
+			if _, ok := seen[child]; !ok {
+				seen[child] = DirEntry{Name: child, IsDir: true}
+			}
+			continue
+		}
+		seen[rest] = DirEntry{Name: rest, Size: entry.header.Size, ModTime: entry.header.ModTime}
+	}
+
+	out := make([]DirEntry, 0, len(seen))
+	for _, entry := range seen {
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+func tarHasChild(entries map[string]*tarEntry, name string) bool {
+	prefix := name + "/"
+	for path := range entries {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type tarFile struct {
+	entry  *tarEntry
+	reader *bytes.Reader
+}
+
+func (self *tarFile) Read(buf []byte) (int, error) { return self.reader.Read(buf) }
+func (self *tarFile) Seek(offset int64, whence int) (int64, error) {
+	return self.reader.Seek(offset, whence)
+}
+func (self *tarFile) Stat() (fs.FileInfo, error) { return self.entry.header.FileInfo(), nil }
+func (self *tarFile) Close() error               { return nil }
+
+func isGzipPath(path string) bool {
+	return len(path) >= 3 && path[len(path)-3:] == ".gz"
+}
+
+/*
+Remote `.zip` archive, read via ranged GET requests through `httpReaderAt`,
+which lets `archive/zip.NewReader` seek around the file without downloading
+it in full. The central directory (at the end of the archive) and individual
+entries are fetched on demand.
+*/
+type remoteZipVFS struct {
+	url string
+
+	mu       sync.Mutex
+	readerAt httpReaderAt
+	reader   *zip.Reader
+	mtime    time.Time
+}
+
+/*
+Lazily fetches the central directory on first use. Deliberately doesn't use
+`sync.Once`: this backend is meant to front a long-running process against
+object storage, and a transient network hiccup on the very first request
+must not permanently poison every request after it. Only a successful
+result is cached; a failed attempt just leaves `self.reader` nil so the
+next request tries again.
+*/
+func (self *remoteZipVFS) init() (*zip.Reader, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.reader != nil {
+		return self.reader, nil
+	}
+
+	size, mtime, err := httpHead(self.url)
+	if err != nil {
+		return nil, err
+	}
+
+	readerAt := httpReaderAt{self.url}
+	reader, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return nil, err
+	}
+
+	self.mtime = mtime
+	self.readerAt = readerAt
+	self.reader = reader
+	return self.reader, nil
+}
+
+func (self *remoteZipVFS) Open(name string) (fs.File, error) {
+	reader, err := self.init()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := findZipEntry(reader, name)
+	if err != nil {
+		return nil, err
+	}
+	// Not backed by `zipCache`: `self.readerAt` lives as long as the
+	// `remoteZipVFS` itself, so there's no eviction to guard against.
+	return newZipEntryFile(self.readerAt, self.mtime, entry, nil)
+}
+
+func (self *remoteZipVFS) Stat(name string) (fs.FileInfo, error) {
+	reader, err := self.init()
+	if err != nil {
+		return nil, err
+	}
+	return zipStat(reader.File, self.mtime, name)
+}
+
+func (self *remoteZipVFS) ReadDir(name string) ([]DirEntry, error) {
+	reader, err := self.init()
+	if err != nil {
+		return nil, err
+	}
+	return zipReadDir(reader.File, name), nil
+}
+
+// Fetches the remote archive's size (for `zip.NewReader`) and mtime (for `ETag`/caching).
+func httpHead(url string) (size int64, mtime time.Time, err error) {
+	resp, err := http.Head(url)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, time.Time{}, errors.New(`srv: unexpected status fetching "` + url + `": ` + resp.Status)
+	}
+	if lastMod := resp.Header.Get("Last-Modified"); lastMod != "" {
+		mtime, _ = http.ParseTime(lastMod)
+	}
+	return resp.ContentLength, mtime, nil
+}
+
+/*
+Implements `io.ReaderAt` by issuing a ranged GET request per call. Used to
+let `archive/zip.NewReader` treat a remote file as if it were local.
+*/
+type httpReaderAt struct{ url string }
+
+func (self httpReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, self.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(`Range`, rangeHeader(off, off+int64(len(buf))-1))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// A 200 means the server ignored `Range` and sent the whole file from
+	// byte 0: reading straight into `buf` would silently return the file's
+	// prefix instead of the requested range, so treat it as an error rather
+	// than let `archive/zip` parse garbage.
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, errors.New(`srv: range request not honored fetching "` + self.url + `": ` + resp.Status)
+	}
+
+	return io.ReadFull(resp.Body, buf)
+}
+
+func rangeHeader(start, end int64) string {
+	return "bytes=" + strconv.FormatInt(start, 10) + "-" + strconv.FormatInt(end, 10)
+}
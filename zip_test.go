@@ -0,0 +1,114 @@
+package srv
+
+import (
+	"archive/zip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Builds a .zip archive containing one entry with the given compression
+// method and returns its path.
+func buildTestZip(t *testing.T, method uint16, name string, content []byte) string {
+	t.Helper()
+
+	archivePath := filepath.Join(t.TempDir(), "site.zip")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := zip.NewWriter(file)
+	entryWriter, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entryWriter.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return archivePath
+}
+
+// A `zip.Store` entry is read via `io.SectionReader` straight off the
+// archive's `*os.File` (see `newZipEntryFile`), so it needs its own coverage
+// of Range support distinct from the Deflate/buffered path.
+func TestZipFileRangeRequestStoreMethod(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	archivePath := buildTestZip(t, zip.Store, "file.txt", content)
+
+	server := New(Zip(archivePath))
+
+	rew := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	server.ServeHTTP(rew, req)
+
+	if rew.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d: %s", rew.Code, rew.Body.String())
+	}
+	if got, want := rew.Body.String(), "2345"; got != want {
+		t.Fatalf("unexpected range body: got %q, want %q", got, want)
+	}
+	if cr := rew.Header().Get("Content-Range"); cr != "bytes 2-5/20" {
+		t.Fatalf("unexpected Content-Range: %q", cr)
+	}
+}
+
+// A Deflate entry is fully buffered before serving (see `newZipEntryFile`),
+// but still needs to support Range the same way a Store entry does.
+func TestZipFileRangeRequestDeflateMethod(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	archivePath := buildTestZip(t, zip.Deflate, "file.txt", content)
+
+	server := New(Zip(archivePath))
+
+	rew := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	server.ServeHTTP(rew, req)
+
+	if rew.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d: %s", rew.Code, rew.Body.String())
+	}
+	if got, want := rew.Body.String(), "2345"; got != want {
+		t.Fatalf("unexpected range body: got %q, want %q", got, want)
+	}
+}
+
+// A zip entry's ETag (derived from archive mtime + CRC32) must round-trip
+// through If-None-Match into a 304, same as a local file's mtime-derived
+// Last-Modified would.
+func TestZipFileConditionalGet(t *testing.T) {
+	archivePath := buildTestZip(t, zip.Store, "file.txt", []byte("hello world"))
+	server := New(Zip(archivePath))
+
+	rew := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	server.ServeHTTP(rew, req)
+
+	if rew.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rew.Code)
+	}
+	etag := rew.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected a non-empty ETag")
+	}
+
+	rew2 := httptest.NewRecorder()
+	req2 := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	req2.Header.Set("If-None-Match", etag)
+	server.ServeHTTP(rew2, req2)
+
+	if rew2.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", rew2.Code)
+	}
+}
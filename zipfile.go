@@ -0,0 +1,310 @@
+package srv
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Upper bound on how many distinct `.zip` archives are kept open at once. Each
+served site normally opens just one, so this mainly protects a process that
+fronts several archives from accumulating unbounded open file descriptors.
+*/
+const zipCacheSize = 16
+
+var zipCache = newZipCache(zipCacheSize)
+
+// One archive kept open by `zipCache`, identified by path+mtime.
+type openZip struct {
+	file   *os.File
+	reader *zip.Reader
+	mtime  time.Time
+
+	/*
+		Reference count: 1 for `zipCache`'s own hold, plus 1 per in-flight
+		`zipEntryFile` reading `file` directly (see `newZipEntryFile`'s
+		`zip.Store` case). `release` closes `file` once this reaches zero, so
+		eviction never closes a file a `Store`-method entry is still streaming
+		from.
+	*/
+	refs int32
+}
+
+func newOpenZip(file *os.File, reader *zip.Reader, mtime time.Time) *openZip {
+	return &openZip{file: file, reader: reader, mtime: mtime, refs: 1}
+}
+
+func (self *openZip) acquire() { atomic.AddInt32(&self.refs, 1) }
+
+func (self *openZip) release() {
+	if atomic.AddInt32(&self.refs, -1) == 0 {
+		self.file.Close()
+	}
+}
+
+/*
+Process-wide LRU of open archives, keyed by path+mtime so a file replaced on
+disk is picked up as a cache miss rather than serving stale contents.
+*/
+type zipCacheT struct {
+	mu      sync.Mutex
+	max     int
+	order   []string
+	entries map[string]*openZip
+}
+
+func newZipCache(max int) *zipCacheT {
+	return &zipCacheT{max: max, entries: map[string]*openZip{}}
+}
+
+func (self *zipCacheT) get(path string) (*openZip, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	key := path + "@" + strconv.FormatInt(stat.ModTime().UnixNano(), 10)
+
+	self.mu.Lock()
+	existing, ok := self.entries[key]
+	if ok {
+		self.touch(key)
+	}
+	self.mu.Unlock()
+	if ok {
+		return existing, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := zip.NewReader(file, stat.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	opened := newOpenZip(file, reader, stat.ModTime())
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	// Lost a race with another goroutine opening the same archive: keep
+	// theirs, since it's equally fresh, and close our redundant handle.
+	if prior, ok := self.entries[key]; ok {
+		opened.release()
+		self.touch(key)
+		return prior, nil
+	}
+
+	self.entries[key] = opened
+	self.order = append(self.order, key)
+	for len(self.order) > self.max {
+		evictKey := self.order[0]
+		self.order = self.order[1:]
+		// Drops the cache's own reference; the underlying file only closes
+		// once no in-flight `zipEntryFile` is still reading it either.
+		self.entries[evictKey].release()
+		delete(self.entries, evictKey)
+	}
+	return opened, nil
+}
+
+// Caller must hold `self.mu`.
+func (self *zipCacheT) touch(key string) {
+	for ind, val := range self.order {
+		if val == key {
+			self.order = append(self.order[:ind], self.order[ind+1:]...)
+			break
+		}
+	}
+	self.order = append(self.order, key)
+}
+
+func findZipEntry(reader *zip.Reader, name string) (*zip.File, error) {
+	for _, entry := range reader.File {
+		if entry.Name == name {
+			return entry, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+/*
+Adapts one `*zip.File` entry into a seekable `fs.File`, so it can go through
+`http.ServeContent` and get Range requests, conditional GETs, and `HEAD` for
+free. Entries stored without compression are read straight off the archive's
+`io.ReaderAt` (its `*os.File` for a local archive, ranged GETs for a remote
+one) via `DataOffset` + `io.SectionReader`; compressed entries have no
+random access, so they're inflated into memory once per request instead.
+*/
+type zipEntryFile struct {
+	entry  *zip.File
+	mtime  time.Time
+	reader io.ReadSeeker
+
+	// Drops the `openZip` reference acquired for this entry, if any; see
+	// `newZipEntryFile`. Guarded by `once` since `fs.File.Close` may be
+	// called more than once.
+	release func()
+	once    sync.Once
+}
+
+/*
+Adapts `entry` into a seekable `fs.File`. `release`, if non-nil, must be
+called exactly once the returned file is done reading from `readerAt` — for
+a `zip.Store` entry that's on `Close`, since it streams from `readerAt` for
+as long as the caller holds it open; for a compressed entry it's called
+immediately below, since the whole entry is inflated into memory before
+this function returns. Callers backed by `zipCache` pass `opened.release`
+so an archive evicted mid-read isn't closed out from under them; callers
+with no such cache (e.g. `remoteZipVFS`) pass nil.
+*/
+func newZipEntryFile(readerAt io.ReaderAt, mtime time.Time, entry *zip.File, release func()) (fs.File, error) {
+	if entry.Method == zip.Store {
+		offset, err := entry.DataOffset()
+		if err != nil {
+			if release != nil {
+				release()
+			}
+			return nil, err
+		}
+		section := io.NewSectionReader(readerAt, offset, int64(entry.CompressedSize64))
+		return &zipEntryFile{entry: entry, mtime: mtime, reader: section, release: release}, nil
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		if release != nil {
+			release()
+		}
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if release != nil {
+		release()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &zipEntryFile{entry: entry, mtime: mtime, reader: bytes.NewReader(data)}, nil
+}
+
+func (self *zipEntryFile) Read(buf []byte) (int, error) { return self.reader.Read(buf) }
+
+func (self *zipEntryFile) Seek(offset int64, whence int) (int64, error) {
+	return self.reader.Seek(offset, whence)
+}
+
+func (self *zipEntryFile) Close() error {
+	self.once.Do(func() {
+		if self.release != nil {
+			self.release()
+		}
+	})
+	return nil
+}
+
+func (self *zipEntryFile) Stat() (fs.FileInfo, error) { return self.entry.FileInfo(), nil }
+
+// Implements the anonymous `ETag() string` interface consulted by `setETag`.
+func (self *zipEntryFile) ETag() string {
+	return fmt.Sprintf(`"%x-%08x"`, self.mtime.Unix(), self.entry.CRC32)
+}
+
+/*
+Zip archives have no entry for their own root, and often no explicit entries
+for intermediate directories either: a file "a/b.txt" implies a directory
+"a" without the archive ever saying so. `zipStat` and `zipReadDir` treat
+`.` and any name that's a strict prefix of some entry as such an implicit
+directory.
+*/
+func zipStat(files []*zip.File, mtime time.Time, name string) (fs.FileInfo, error) {
+	if name == "." {
+		return syntheticDirInfo{name: ".", mtime: mtime}, nil
+	}
+	for _, entry := range files {
+		if entry.Name == name {
+			return entry.FileInfo(), nil
+		}
+	}
+	if hasZipChild(files, name) {
+		return syntheticDirInfo{name: fsBase(name), mtime: mtime}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func hasZipChild(files []*zip.File, name string) bool {
+	prefix := name + "/"
+	for _, entry := range files {
+		if strings.HasPrefix(entry.Name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lists the immediate children of `name`, synthesizing directories as needed.
+func zipReadDir(files []*zip.File, name string) []DirEntry {
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+
+	seen := map[string]DirEntry{}
+	for _, entry := range files {
+		if !strings.HasPrefix(entry.Name, prefix) {
+			continue
+		}
+		rest := entry.Name[len(prefix):]
+		if rest == "" {
+			continue
+		}
+		if idx := strings.IndexByte(rest, '/'); idx >= 0 {
+			child := rest[:idx]
+			if _, ok := seen[child]; !ok {
+				seen[child] = DirEntry{Name: child, IsDir: true}
+			}
+			continue
+		}
+		info := entry.FileInfo()
+		seen[rest] = DirEntry{Name: rest, Size: info.Size(), ModTime: info.ModTime()}
+	}
+
+	out := make([]DirEntry, 0, len(seen))
+	for _, entry := range seen {
+		out = append(out, entry)
+	}
+	return out
+}
+
+func fsBase(name string) string {
+	if idx := strings.LastIndexByte(name, '/'); idx >= 0 {
+		return name[idx+1:]
+	}
+	return name
+}
+
+// Minimal synthetic `fs.FileInfo` for archive directories with no entry of their own.
+type syntheticDirInfo struct {
+	name  string
+	mtime time.Time
+}
+
+func (self syntheticDirInfo) Name() string       { return self.name }
+func (self syntheticDirInfo) Size() int64        { return 0 }
+func (self syntheticDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (self syntheticDirInfo) ModTime() time.Time { return self.mtime }
+func (self syntheticDirInfo) IsDir() bool        { return true }
+func (self syntheticDirInfo) Sys() any           { return nil }